@@ -0,0 +1,196 @@
+package modules
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/bettercap-ng/network"
+	"github.com/evilsocket/bettercap-ng/session"
+)
+
+const (
+	aliveTimeInterval      = time.Duration(10) * time.Second
+	presentTimeInterval    = time.Duration(1) * time.Minute
+	justJoinedTimeInterval = time.Duration(10) * time.Second
+
+	// rssiSamples is how many recent RSSI readings we keep per station.
+	rssiSamples = 10
+)
+
+// WiFiStation represents a discovered 802.11 access point or client.
+type WiFiStation struct {
+	HW        net.HardwareAddr
+	HwAddress string
+	essid     string
+	Vendor    string
+	Channel   int
+	IsAP      bool
+	FirstSeen time.Time
+	LastSeen  time.Time
+
+	RSSI        int8
+	rssiSamples []int8
+
+	// IsClient is true for stations discovered via probe requests rather
+	// than through traffic to/from an associated access point.
+	IsClient bool
+
+	probedLock  sync.Mutex
+	ProbedSSIDs map[string]bool
+}
+
+func NewWiFiStation(essid, bssid string, isAP bool, channel int) *WiFiStation {
+	hw, _ := net.ParseMAC(bssid)
+	now := time.Now()
+	return &WiFiStation{
+		HW:          hw,
+		HwAddress:   bssid,
+		essid:       essid,
+		Vendor:      network.ManufLookup(bssid),
+		Channel:     channel,
+		IsAP:        isAP,
+		FirstSeen:   now,
+		LastSeen:    now,
+		rssiSamples: make([]int8, 0, rssiSamples),
+		ProbedSSIDs: make(map[string]bool),
+	}
+}
+
+// AddProbedSSID records ssid as a network this station has probed for.
+// Wildcard probes (empty SSID) are not tracked, since they carry no
+// information about a preferred network.
+func (s *WiFiStation) AddProbedSSID(ssid string) {
+	if ssid == "" {
+		return
+	}
+	s.probedLock.Lock()
+	defer s.probedLock.Unlock()
+
+	s.ProbedSSIDs[ssid] = true
+}
+
+// ProbedSSIDList returns the station's probed SSIDs as a sorted,
+// comma-separated string for display.
+func (s *WiFiStation) ProbedSSIDList() string {
+	s.probedLock.Lock()
+	defer s.probedLock.Unlock()
+
+	list := make([]string, 0, len(s.ProbedSSIDs))
+	for ssid := range s.ProbedSSIDs {
+		list = append(list, ssid)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+func (s *WiFiStation) ESSID() string {
+	if s.essid == "" {
+		return "<hidden>"
+	}
+	return s.essid
+}
+
+// UpdateRSSI appends a new RSSI sample (in dBm) to the station's ring
+// buffer and updates the last known reading shown in Show().
+func (s *WiFiStation) UpdateRSSI(dbm int8) {
+	s.RSSI = dbm
+	if len(s.rssiSamples) == rssiSamples {
+		s.rssiSamples = s.rssiSamples[1:]
+	}
+	s.rssiSamples = append(s.rssiSamples, dbm)
+}
+
+func (s *WiFiStation) MarshalJSON() ([]byte, error) {
+	type jsonStation struct {
+		HwAddress string `json:"mac"`
+		ESSID     string `json:"essid"`
+		Vendor    string `json:"vendor"`
+		Channel   int    `json:"channel"`
+		RSSI      int8   `json:"rssi"`
+		IsAP      bool   `json:"is_ap"`
+		FirstSeen time.Time `json:"first_seen"`
+		LastSeen  time.Time `json:"last_seen"`
+	}
+
+	return json.Marshal(jsonStation{
+		HwAddress: s.HwAddress,
+		ESSID:     s.ESSID(),
+		Vendor:    s.Vendor,
+		Channel:   s.Channel,
+		RSSI:      s.RSSI,
+		IsAP:      s.IsAP,
+		FirstSeen: s.FirstSeen,
+		LastSeen:  s.LastSeen,
+	})
+}
+
+// WiFi tracks every access point and client station discovered by
+// WiFiRecon for the current session.
+type WiFi struct {
+	sync.Mutex
+
+	Session   *session.Session
+	Interface *network.Endpoint
+	Stations  map[string]*WiFiStation
+}
+
+func NewWiFi(s *session.Session, iface *network.Endpoint) *WiFi {
+	return &WiFi{
+		Session:   s,
+		Interface: iface,
+		Stations:  make(map[string]*WiFiStation),
+	}
+}
+
+func (w *WiFi) AddIfNew(essid, bssid string, isAP bool, channel int) *WiFiStation {
+	w.Lock()
+	defer w.Unlock()
+
+	if station, found := w.Stations[bssid]; found {
+		station.LastSeen = time.Now()
+		return station
+	}
+
+	station := NewWiFiStation(essid, bssid, isAP, channel)
+	w.Stations[bssid] = station
+	return station
+}
+
+// AddClientIfNew records a station discovered via a probe request, i.e.
+// one that hasn't necessarily associated to any access point yet.
+func (w *WiFi) AddClientIfNew(bssid string, channel int) *WiFiStation {
+	w.Lock()
+	defer w.Unlock()
+
+	if station, found := w.Stations[bssid]; found {
+		station.LastSeen = time.Now()
+		return station
+	}
+
+	station := NewWiFiStation("", bssid, false, channel)
+	station.IsClient = true
+	w.Stations[bssid] = station
+	return station
+}
+
+func (w *WiFi) List() []*WiFiStation {
+	w.Lock()
+	defer w.Unlock()
+
+	stations := make([]*WiFiStation, 0, len(w.Stations))
+	for _, s := range w.Stations {
+		stations = append(stations, s)
+	}
+	return stations
+}
+
+func (w *WiFi) Clear() {
+	w.Lock()
+	defer w.Unlock()
+
+	w.Stations = make(map[string]*WiFiStation)
+}
@@ -0,0 +1,291 @@
+package modules
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/evilsocket/bettercap-ng/log"
+	"github.com/evilsocket/bettercap-ng/network"
+	"github.com/evilsocket/bettercap-ng/session"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// EAPOL-Key Information field bits we care about to tell the four
+// messages of the WPA/WPA2 4-way handshake apart (802.11-2016, 12.7.2).
+const (
+	eapolKeyInfoPairwise = 1 << 3
+	eapolKeyInfoInstall  = 1 << 6
+	eapolKeyInfoAck      = 1 << 7
+	eapolKeyInfoMIC      = 1 << 8
+	eapolKeyInfoSecure   = 1 << 9
+)
+
+// eapolKeyInfo extracts the big-endian Key Information field out of the
+// raw EAPOL-Key descriptor payload (descriptor type byte, then the 2 byte
+// Key Information field).
+func eapolKeyInfo(payload []byte) (uint16, bool) {
+	if len(payload) < 3 {
+		return 0, false
+	}
+	return uint16(payload[1])<<8 | uint16(payload[2]), true
+}
+
+// eapolMessageIndex classifies an EAPOL-Key frame as one of the four
+// messages of the 4-way handshake (0 to 3) based on its Key Information
+// flags, or returns -1 if it doesn't belong to a pairwise handshake.
+func eapolMessageIndex(keyInfo uint16) int {
+	if keyInfo&eapolKeyInfoPairwise == 0 {
+		return -1
+	}
+
+	ack := keyInfo&eapolKeyInfoAck != 0
+	mic := keyInfo&eapolKeyInfoMIC != 0
+	install := keyInfo&eapolKeyInfoInstall != 0
+	secure := keyInfo&eapolKeyInfoSecure != 0
+
+	switch {
+	case ack && !mic:
+		return 0
+	case !ack && mic && !secure:
+		return 1
+	case ack && mic && install:
+		return 2
+	case !ack && mic && secure:
+		return 3
+	}
+	return -1
+}
+
+type handshakeFrame struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// handshakeState accumulates the four EAPOL-Key frames exchanged between
+// one access point and one client while a 4-way handshake is in flight.
+type handshakeState struct {
+	ap     net.HardwareAddr
+	client net.HardwareAddr
+	essid  string
+	frames [4]*handshakeFrame
+	seen   [4]bool
+}
+
+func (hs *handshakeState) add(idx int, frame *handshakeFrame) {
+	// message 1 always opens a new handshake attempt, discard anything
+	// we were holding from a previous (failed) attempt
+	if idx == 0 {
+		hs.seen = [4]bool{}
+		hs.frames = [4]*handshakeFrame{}
+	}
+	hs.seen[idx] = true
+	hs.frames[idx] = frame
+}
+
+func (hs *handshakeState) complete() bool {
+	return hs.seen[0] && hs.seen[1] && hs.seen[2] && hs.seen[3]
+}
+
+func handshakeKey(ap, client net.HardwareAddr) string {
+	return ap.String() + "|" + client.String()
+}
+
+// WiFiHandshakes arms and tracks WPA/WPA2 4-way handshake captures for
+// (access point, client) pairs, dumping completed handshakes to their own
+// pcapng file as soon as all four EAPOL-Key messages have been observed.
+type WiFiHandshakes struct {
+	sync.Mutex
+
+	path   string
+	armed  map[string]bool
+	states map[string]*handshakeState
+}
+
+func NewWiFiHandshakes() *WiFiHandshakes {
+	return &WiFiHandshakes{
+		armed:  make(map[string]bool),
+		states: make(map[string]*handshakeState),
+	}
+}
+
+// SetPath sets the directory completed handshakes are written to.
+func (h *WiFiHandshakes) SetPath(path string) {
+	h.Lock()
+	defer h.Unlock()
+	h.path = path
+}
+
+// Arm enables handshake capture for the (ap, client) pair, as done
+// automatically whenever wifi.deauth targets it.
+func (h *WiFiHandshakes) Arm(ap, client net.HardwareAddr, essid string) {
+	h.Lock()
+	defer h.Unlock()
+
+	key := handshakeKey(ap, client)
+	h.armed[key] = true
+	if _, found := h.states[key]; !found {
+		h.states[key] = &handshakeState{ap: ap, client: client, essid: essid}
+	}
+}
+
+// Process inspects packet for an EAPOL-Key frame belonging to an armed
+// (ap, client) pair, records it, and once all four handshake messages
+// have been seen, flushes them to a dedicated pcapng file under path and
+// raises a wifi.handshake session event.
+func (h *WiFiHandshakes) Process(s *session.Session, packet gopacket.Packet) {
+	eapolLayer := packet.Layer(layers.LayerTypeEAPOL)
+	if eapolLayer == nil {
+		return
+	}
+
+	eapol, _ := eapolLayer.(*layers.EAPOL)
+	if eapol.Type != layers.EAPOLTypeKey {
+		return
+	}
+
+	dot11Layer := packet.Layer(layers.LayerTypeDot11)
+	if dot11Layer == nil {
+		return
+	}
+	dot11, _ := dot11Layer.(*layers.Dot11)
+
+	keyInfo, ok := eapolKeyInfo(eapol.LayerPayload())
+	if !ok {
+		return
+	}
+
+	idx := eapolMessageIndex(keyInfo)
+	if idx == -1 {
+		return
+	}
+
+	// message 1 and 3 go from AP to client (FromDS), message 2 and 4 go
+	// from client to AP (ToDS)
+	var ap, client net.HardwareAddr
+	if idx == 0 || idx == 2 {
+		ap, client = dot11.Address2, dot11.Address1
+	} else {
+		ap, client = dot11.Address1, dot11.Address2
+	}
+
+	h.Lock()
+	key := handshakeKey(ap, client)
+	if !h.armed[key] {
+		h.Unlock()
+		return
+	}
+	state, found := h.states[key]
+	if !found {
+		state = &handshakeState{ap: ap, client: client}
+		h.states[key] = state
+	}
+
+	state.add(idx, &handshakeFrame{data: packet.Data(), ci: packet.Metadata().CaptureInfo})
+	complete := state.complete()
+	if complete {
+		delete(h.armed, key)
+		delete(h.states, key)
+	}
+	h.Unlock()
+
+	if complete {
+		if err := h.save(state); err != nil {
+			log.Warning("Could not save handshake for %s / %s: %s", ap, client, err)
+			return
+		}
+		s.Events.Add("wifi.handshake", map[string]string{
+			"ap":     ap.String(),
+			"client": client.String(),
+			"essid":  state.essid,
+		})
+	}
+}
+
+// save writes the four captured EAPOL frames, preceded by a synthetic beacon
+// carrying the AP's SSID when that SSID is known (so the capture is
+// self-contained for tools like aircrack-ng/hashcat), to their own pcapng
+// file under h.path. Without a known SSID no beacon is written.
+func (h *WiFiHandshakes) save(state *handshakeState) error {
+	h.Lock()
+	path := h.path
+	h.Unlock()
+
+	if path == "" {
+		return fmt.Errorf("wifi.handshakes.path is not set")
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s_%s.pcapng", state.ap.String(), state.client.String())
+	file, err := os.Create(filepath.Join(path, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer, err := pcapgo.NewNgWriter(file, layers.LinkTypeIEEE80211Radio)
+	if err != nil {
+		return err
+	}
+
+	if beacon := buildBeacon(state.ap, state.essid); beacon != nil {
+		if err := writer.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     state.frames[0].ci.Timestamp,
+			CaptureLength: len(beacon),
+			Length:        len(beacon),
+		}, beacon); err != nil {
+			return err
+		}
+	}
+
+	for _, frame := range state.frames {
+		if frame == nil {
+			continue
+		}
+		if err := writer.WritePacket(frame.ci, frame.data); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+// buildBeacon crafts a minimal 802.11 beacon frame advertising essid from
+// bssid, so the handshake capture carries the SSID without relying on the
+// cracking tool to have seen one separately. Returns nil if essid is
+// unknown (e.g. the AP was only ever seen via the handshake itself).
+func buildBeacon(bssid net.HardwareAddr, essid string) []byte {
+	if essid == "" {
+		return nil
+	}
+
+	dot11 := &layers.Dot11{
+		Type:     layers.Dot11TypeMgmtBeacon,
+		Address1: network.BroadcastMac,
+		Address2: bssid,
+		Address3: bssid,
+	}
+	beacon := &layers.Dot11MgmtBeacon{
+		Interval: 100,
+		Flags:    0x31,
+	}
+	ssid := &layers.Dot11InformationElement{
+		ID:     layers.Dot11InformationElementIDSSID,
+		Length: uint8(len(essid)),
+		Info:   []byte(essid),
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, dot11, beacon, ssid); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
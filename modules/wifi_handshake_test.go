@@ -0,0 +1,95 @@
+package modules
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// canned Key Information field values for each of the four messages of a
+// WPA2 4-way handshake, as they appear on the wire (big-endian, masked to
+// the bits eapolMessageIndex actually inspects).
+const (
+	msg1KeyInfo = eapolKeyInfoPairwise | eapolKeyInfoAck
+	msg2KeyInfo = eapolKeyInfoPairwise | eapolKeyInfoMIC
+	msg3KeyInfo = eapolKeyInfoPairwise | eapolKeyInfoAck | eapolKeyInfoMIC | eapolKeyInfoInstall | eapolKeyInfoSecure
+	msg4KeyInfo = eapolKeyInfoPairwise | eapolKeyInfoMIC | eapolKeyInfoSecure
+)
+
+func eapolKeyPayload(keyInfo uint16) []byte {
+	// descriptor type, then the 2 byte Key Information field: the shortest
+	// payload eapolKeyInfo accepts, not a full EAPOL-Key descriptor
+	return []byte{2, byte(keyInfo >> 8), byte(keyInfo)}
+}
+
+func TestEapolMessageIndex(t *testing.T) {
+	cases := []struct {
+		name    string
+		keyInfo uint16
+		index   int
+	}{
+		{"message 1", msg1KeyInfo, 0},
+		{"message 2", msg2KeyInfo, 1},
+		{"message 3", msg3KeyInfo, 2},
+		{"message 4", msg4KeyInfo, 3},
+		{"group key handshake (not pairwise)", eapolKeyInfoAck | eapolKeyInfoMIC, -1},
+	}
+
+	for _, c := range cases {
+		if got := eapolMessageIndex(c.keyInfo); got != c.index {
+			t.Errorf("%s: expected index '%d', got '%d'", c.name, c.index, got)
+		}
+	}
+}
+
+func TestEapolKeyInfo(t *testing.T) {
+	payload := eapolKeyPayload(msg3KeyInfo)
+	keyInfo, ok := eapolKeyInfo(payload)
+	if !ok {
+		t.Fatal("expected a valid Key Information field")
+	}
+	if keyInfo != msg3KeyInfo {
+		t.Fatalf("expected '%d', got '%d'", msg3KeyInfo, keyInfo)
+	}
+
+	if _, ok := eapolKeyInfo([]byte{1}); ok {
+		t.Fatal("expected a truncated payload to be rejected")
+	}
+}
+
+func TestHandshakeStateComplete(t *testing.T) {
+	ap, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	client, _ := net.ParseMAC("11:22:33:44:55:66")
+	state := &handshakeState{ap: ap, client: client, essid: "TestNet"}
+
+	frame := func() *handshakeFrame {
+		return &handshakeFrame{data: []byte{0x01}, ci: gopacket.CaptureInfo{}}
+	}
+
+	for idx := 0; idx < 4; idx++ {
+		if state.complete() {
+			t.Fatalf("state reported complete after only %d messages", idx)
+		}
+		state.add(idx, frame())
+	}
+
+	if !state.complete() {
+		t.Fatal("expected state to be complete after all four messages")
+	}
+}
+
+func TestHandshakeStateResetsOnNewMessageOne(t *testing.T) {
+	ap, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	client, _ := net.ParseMAC("11:22:33:44:55:66")
+	state := &handshakeState{ap: ap, client: client}
+
+	frame := &handshakeFrame{data: []byte{0x01}, ci: gopacket.CaptureInfo{}}
+	state.add(0, frame)
+	state.add(1, frame)
+	state.add(0, frame) // a retried message 1 should drop message 2
+
+	if state.seen[1] {
+		t.Fatal("expected message 2 to be discarded after a new message 1")
+	}
+}
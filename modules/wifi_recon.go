@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"runtime"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/evilsocket/bettercap-ng/core"
@@ -19,11 +22,23 @@ import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
 
 	"github.com/dustin/go-humanize"
 	"github.com/olekukonko/tablewriter"
 )
 
+const defaultHopPeriod = 250 * time.Millisecond
+
+// minHopPeriod is the smallest dwell time we'll accept for wifi.recon.hop.period;
+// anything lower turns the hopper into a busy spin that pegs a core shelling
+// out to `iw` and hammers the NIC driver with back-to-back channel changes.
+const minHopPeriod = 50 * time.Millisecond
+
+// pcapngFlushEvery is how many captured frames we buffer before flushing
+// the pcapng writer to disk.
+const pcapngFlushEvery = 100
+
 type WiFiRecon struct {
 	session.SessionModule
 
@@ -32,6 +47,19 @@ type WiFiRecon struct {
 	handle      *pcap.Handle
 	client      net.HardwareAddr
 	accessPoint net.HardwareAddr
+
+	channels  []int
+	hopPeriod time.Duration
+	hopLock   sync.Mutex
+	hopQuit   chan bool
+
+	pcapngPath    string
+	pcapngFile    *os.File
+	pcapngWriter  *pcapgo.NgWriter
+	pcapngLock    sync.Mutex
+	pcapngPending int
+
+	handshakes *WiFiHandshakes
 }
 
 func NewWiFiRecon(s *session.Session) *WiFiRecon {
@@ -40,6 +68,9 @@ func NewWiFiRecon(s *session.Session) *WiFiRecon {
 		stats:         NewWiFiStats(),
 		client:        make([]byte, 0),
 		accessPoint:   make([]byte, 0),
+		channels:      append(append([]int{}, network.Default2Ghz...), network.Default5Ghz...),
+		hopPeriod:     defaultHopPeriod,
+		handshakes:    NewWiFiHandshakes(),
 	}
 
 	w.AddHandler(session.NewModuleHandler("wifi.recon on", "",
@@ -79,11 +110,18 @@ func NewWiFiRecon(s *session.Session) *WiFiRecon {
 		"Set 802.11 base station address to filter for.",
 		func(args []string) error {
 			var err error
+			if w.accessPoint, err = net.ParseMAC(args[0]); err != nil {
+				return err
+			}
+			// lock hopping to the target's last known channel instead of
+			// sweeping the whole spectrum while we're only interested in
+			// it; this must happen before Clear() below, since it's the
+			// last-seen channel from the station list that we need.
+			w.lockChannelFor(w.accessPoint)
 			if w.wifi != nil {
 				w.wifi.Clear()
 			}
-			w.accessPoint, err = net.ParseMAC(args[0])
-			return err
+			return nil
 		}))
 
 	w.AddHandler(session.NewModuleHandler("wifi.recon clear bs", "",
@@ -93,6 +131,44 @@ func NewWiFiRecon(s *session.Session) *WiFiRecon {
 				w.wifi.Clear()
 			}
 			w.accessPoint = make([]byte, 0)
+			w.startHopping()
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wifi.recon.channels CHANNELS", `wifi\.recon\.channels ([0-9,]+)`,
+		"Comma separated list of channels to hop on while channel hopping is active, resets to the full 2.4/5 GHz set if empty.",
+		func(args []string) error {
+			return w.setChannels(args[0])
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wifi.handshakes.path PATH", `wifi\.handshakes\.path (.+)`,
+		"Directory completed WPA/WPA2 4-way handshakes are written to as individual pcapng files, one per (AP, client) pair.",
+		func(args []string) error {
+			w.handshakes.SetPath(args[0])
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wifi.recon.output PATH", `wifi\.recon\.output (.+)`,
+		"Path of a pcapng file to dump every captured 802.11 frame to, must be set before wifi.recon on.",
+		func(args []string) error {
+			w.pcapngPath = args[0]
+			return nil
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wifi.recon.hop.period PERIOD", `wifi\.recon\.hop\.period (\d+)`,
+		"Milliseconds to wait on every channel before hopping to the next one.",
+		func(args []string) error {
+			ms, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			period := time.Duration(ms) * time.Millisecond
+			if period < minHopPeriod {
+				return fmt.Errorf("hop period must be at least %s", minHopPeriod)
+			}
+			w.hopLock.Lock()
+			w.hopPeriod = period
+			w.hopLock.Unlock()
 			return nil
 		}))
 
@@ -102,6 +178,24 @@ func NewWiFiRecon(s *session.Session) *WiFiRecon {
 			return w.Show("essid")
 		}))
 
+	w.AddHandler(session.NewModuleHandler("wifi.show rssi", "",
+		"Show current hosts list sorted by signal strength, strongest first.",
+		func(args []string) error {
+			return w.Show("rssi")
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wifi.show clients", "",
+		"Show clients discovered via probe requests, along with their preferred networks.",
+		func(args []string) error {
+			return w.ShowClients()
+		}))
+
+	w.AddHandler(session.NewModuleHandler("wifi.recon.channels.show", "",
+		"Show per-channel dwell time and observed frame counts collected by the channel hopper.",
+		func(args []string) error {
+			return w.ShowChannels()
+		}))
+
 	return w
 }
 
@@ -148,19 +242,12 @@ func (w *WiFiRecon) getRow(station *WiFiStation) []string {
 		station.ESSID(),
 		station.Vendor,
 		strconv.Itoa(station.Channel),
+		strconv.Itoa(int(station.RSSI)),
 		traffic,
 		seen,
 	}
 }
 
-func mhz2chan(freq int) int {
-	if freq <= 2484 {
-		return ((freq - 2412) / 5) + 1
-	}
-
-	return 0
-}
-
 type ByEssidSorter []*WiFiStation
 
 func (a ByEssidSorter) Len() int      { return len(a) }
@@ -180,6 +267,14 @@ func (a BywifiSeenSorter) Less(i, j int) bool {
 	return a[i].LastSeen.After(a[j].LastSeen)
 }
 
+type ByRSSISorter []*WiFiStation
+
+func (a ByRSSISorter) Len() int      { return len(a) }
+func (a ByRSSISorter) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByRSSISorter) Less(i, j int) bool {
+	return a[i].RSSI > a[j].RSSI
+}
+
 func (w *WiFiRecon) showTable(header []string, rows [][]string) {
 	fmt.Println()
 	table := tablewriter.NewWriter(os.Stdout)
@@ -197,6 +292,8 @@ func (w *WiFiRecon) Show(by string) error {
 	stations := w.wifi.List()
 	if by == "seen" {
 		sort.Sort(BywifiSeenSorter(stations))
+	} else if by == "rssi" {
+		sort.Sort(ByRSSISorter(stations))
 	} else {
 		sort.Sort(ByEssidSorter(stations))
 	}
@@ -206,13 +303,69 @@ func (w *WiFiRecon) Show(by string) error {
 		rows = append(rows, w.getRow(s))
 	}
 
-	w.showTable([]string{"BSSID", "SSID", "Vendor", "Channel", "Traffic", "Last Seen"}, rows)
+	w.showTable([]string{"BSSID", "SSID", "Vendor", "Channel", "RSSI dBm", "Traffic", "Last Seen"}, rows)
 
 	w.Session.Refresh()
 
 	return nil
 }
 
+func (w *WiFiRecon) getClientRow(station *WiFiStation) []string {
+	return []string{
+		station.HwAddress,
+		station.Vendor,
+		strconv.Itoa(int(station.RSSI)),
+		station.LastSeen.Format("15:04:05"),
+		station.ProbedSSIDList(),
+	}
+}
+
+// ShowClients prints every station discovered via probe requests, with
+// their last known RSSI and the networks they've shown interest in.
+func (w *WiFiRecon) ShowClients() error {
+	if w.wifi == nil {
+		return errors.New("WiFi is not yet initialized.")
+	}
+
+	stations := w.wifi.List()
+	sort.Sort(ByRSSISorter(stations))
+
+	rows := make([][]string, 0)
+	for _, s := range stations {
+		if !s.IsClient {
+			continue
+		}
+		rows = append(rows, w.getClientRow(s))
+	}
+
+	w.showTable([]string{"MAC", "Vendor", "RSSI dBm", "Last Seen", "Preferred Networks"}, rows)
+
+	w.Session.Refresh()
+
+	return nil
+}
+
+// ShowChannels prints the dwell time and observed frame count the channel
+// hopper has accumulated for every channel it has visited, so users can
+// validate that the configured channel list is actually being covered.
+// Only frames that carry an SSID information element (such as beacons and
+// probes) are counted; other frames do not show up in the frame count.
+func (w *WiFiRecon) ShowChannels() error {
+	rows := make([][]string, 0)
+	for _, ch := range w.stats.Channels() {
+		dwellMs, frames := w.stats.ChannelStats(ch)
+		rows = append(rows, []string{
+			strconv.Itoa(ch),
+			humanize.Comma(int64(dwellMs)) + " ms",
+			humanize.Comma(int64(frames)),
+		})
+	}
+
+	w.showTable([]string{"Channel", "Dwell Time", "Frames Seen"}, rows)
+
+	return nil
+}
+
 func (w *WiFiRecon) Configure() error {
 	ihandle, err := pcap.NewInactiveHandle(w.Session.Interface.Name())
 	if err != nil {
@@ -232,10 +385,220 @@ func (w *WiFiRecon) Configure() error {
 
 	w.wifi = NewWiFi(w.Session, w.Session.Interface)
 
+	if w.pcapngPath != "" {
+		if err = w.openPcapngWriter(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// openPcapngWriter creates w.pcapngPath and writes its pcapng section and
+// interface description blocks, ready for writePcapngFrame to append
+// packets to it.
+func (w *WiFiRecon) openPcapngWriter() error {
+	file, err := os.Create(w.pcapngPath)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %s", w.pcapngPath, err)
+	}
+
+	writer, err := pcapgo.NewNgWriter(file, layers.LinkTypeIEEE80211Radio)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	iface := pcapgo.NgInterface{
+		Name:       w.Session.Interface.Name(),
+		LinkType:   layers.LinkTypeIEEE80211Radio,
+		SnapLength: 65536,
+		OS:         runtime.GOOS,
+		Comment:    "bettercap-ng wifi.recon capture",
+	}
+
+	if _, err = writer.AddInterface(iface); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	w.pcapngLock.Lock()
+	w.pcapngFile = file
+	w.pcapngWriter = writer
+	w.pcapngPending = 0
+	w.pcapngLock.Unlock()
+
 	return nil
 }
 
+// writePcapngFrame appends packet to the capture file, if one is active,
+// flushing every pcapngFlushEvery frames.
+func (w *WiFiRecon) writePcapngFrame(packet gopacket.Packet) {
+	w.pcapngLock.Lock()
+	defer w.pcapngLock.Unlock()
+
+	if w.pcapngWriter == nil {
+		return
+	}
+
+	if err := w.pcapngWriter.WritePacket(packet.Metadata().CaptureInfo, packet.Data()); err != nil {
+		log.Warning("Could not write packet to %s: %s", w.pcapngPath, err)
+		return
+	}
+
+	w.pcapngPending++
+	if w.pcapngPending >= pcapngFlushEvery {
+		if err := w.pcapngWriter.Flush(); err != nil {
+			log.Warning("Could not flush %s: %s", w.pcapngPath, err)
+		}
+		w.pcapngPending = 0
+	}
+}
+
+// closePcapngWriter flushes and closes the capture file, if one is active.
+func (w *WiFiRecon) closePcapngWriter() {
+	w.pcapngLock.Lock()
+	defer w.pcapngLock.Unlock()
+
+	if w.pcapngWriter == nil {
+		return
+	}
+
+	if err := w.pcapngWriter.Flush(); err != nil {
+		log.Warning("Could not flush %s: %s", w.pcapngPath, err)
+	}
+	w.pcapngFile.Close()
+	w.pcapngWriter = nil
+	w.pcapngFile = nil
+}
+
+func (w *WiFiRecon) setChannels(arg string) error {
+	channels := make([]int, 0)
+	for _, part := range strings.Split(arg, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		ch, err := strconv.Atoi(part)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid channel number", part)
+		}
+		channels = append(channels, ch)
+	}
+
+	if len(channels) == 0 {
+		channels = append(append([]int{}, network.Default2Ghz...), network.Default5Ghz...)
+	}
+
+	w.hopLock.Lock()
+	w.channels = channels
+	w.hopLock.Unlock()
+
+	return nil
+}
+
+// lockChannelFor stops the hopper and parks the radio on the last channel
+// the given base station was seen on, if we have one.
+func (w *WiFiRecon) lockChannelFor(bssid net.HardwareAddr) {
+	w.stopHopping()
+
+	if w.wifi == nil {
+		return
+	}
+
+	for _, station := range w.wifi.List() {
+		if bytes.Compare(station.HW, bssid) == 0 {
+			if err := network.SetInterfaceChannel(w.Session.Interface.Name(), station.Channel); err != nil {
+				log.Warning("Could not lock %s to channel %d: %s", w.Session.Interface.Name(), station.Channel, err)
+			}
+			break
+		}
+	}
+}
+
+// startHopping spawns the background goroutine that iterates w.channels,
+// tuning the radio to each in turn and sleeping w.hopPeriod before moving
+// on to the next one. It is a no-op if a base station filter is set, since
+// in that case the radio stays locked to that AP's channel.
+func (w *WiFiRecon) startHopping() {
+	if len(w.accessPoint) > 0 {
+		return
+	}
+
+	w.stopHopping()
+
+	quit := make(chan bool)
+	w.hopLock.Lock()
+	w.hopQuit = quit
+	w.hopLock.Unlock()
+
+	go func() {
+		iface := w.Session.Interface.Name()
+		for {
+			w.hopLock.Lock()
+			channels := w.channels
+			period := w.hopPeriod
+			w.hopLock.Unlock()
+
+			for _, ch := range channels {
+				select {
+				case <-quit:
+					return
+				default:
+				}
+
+				if err := network.SetInterfaceChannel(iface, ch); err != nil {
+					log.Debug("%s", err)
+					continue
+				}
+
+				before := time.Now()
+				select {
+				case <-quit:
+					return
+				case <-time.After(period):
+				}
+
+				w.stats.CollectChannelDwell(ch, uint64(time.Since(before)/time.Millisecond))
+			}
+		}
+	}()
+}
+
+func (w *WiFiRecon) stopHopping() {
+	w.hopLock.Lock()
+	defer w.hopLock.Unlock()
+
+	if w.hopQuit != nil {
+		close(w.hopQuit)
+		w.hopQuit = nil
+	}
+}
+
+// essidFor returns the last known SSID for bssid, or the empty string if
+// the access point hasn't been seen yet.
+func (w *WiFiRecon) essidFor(bssid net.HardwareAddr) string {
+	if w.wifi == nil {
+		return ""
+	}
+	for _, station := range w.wifi.List() {
+		if bytes.Compare(station.HW, bssid) == 0 {
+			return station.essid
+		}
+	}
+	return ""
+}
+
 func (w *WiFiRecon) sendDeauthPacket(ap net.HardwareAddr, client net.HardwareAddr) {
+	// deauthing is mainly useful to force a re-association, so arm
+	// handshake capture for this pair before flooding deauth frames
+	w.handshakes.Arm(ap, client, w.essidFor(ap))
+
 	for seq := uint16(0); seq < 64; seq++ {
 		if err, pkt := packets.NewDot11Deauth(ap, client, ap, layers.Dot11TypeMgmtDeauthentication, layers.Dot11ReasonClass2FromNonAuth, seq); err != nil {
 			log.Error("Could not create deauth packet: %s", err)
@@ -306,8 +669,9 @@ func (w *WiFiRecon) discoverAccessPoints(packet gopacket.Packet) {
 	// packet sent to broadcast mac with a SSID set?
 	if bytes.Compare(dst, network.BroadcastMac) == 0 && len(ssid) > 0 {
 		radiotap, _ := radiotapLayer.(*layers.RadioTap)
-		channel := mhz2chan(int(radiotap.ChannelFrequency))
-		w.wifi.AddIfNew(ssid, bssid, true, channel)
+		channel := network.Dot11Freq2Chan(int(radiotap.ChannelFrequency))
+		station := w.wifi.AddIfNew(ssid, bssid, true, channel)
+		station.UpdateRSSI(int8(radiotap.DBMAntennaSignal))
 	}
 }
 
@@ -336,8 +700,44 @@ func (w *WiFiRecon) discoverClients(bs net.HardwareAddr, packet gopacket.Packet)
 		// packet going to this specific BSSID?
 		if bytes.Compare(bssid, bs) == 0 {
 			radiotap, _ := radiotapLayer.(*layers.RadioTap)
-			channel := mhz2chan(int(radiotap.ChannelFrequency))
-			w.wifi.AddIfNew("", src.String(), false, channel)
+			channel := network.Dot11Freq2Chan(int(radiotap.ChannelFrequency))
+			station := w.wifi.AddIfNew("", src.String(), false, channel)
+			station.UpdateRSSI(int8(radiotap.DBMAntennaSignal))
+		}
+	}
+}
+
+// discoverProbeRequests tracks stations sending 802.11 probe requests,
+// which lets us spot clients that are walking by without ever associating
+// to the access point we're watching.
+func (w *WiFiRecon) discoverProbeRequests(packet gopacket.Packet) {
+	radiotapLayer := packet.Layer(layers.LayerTypeRadioTap)
+	if radiotapLayer == nil {
+		return
+	}
+
+	dot11Layer := packet.Layer(layers.LayerTypeDot11)
+	if dot11Layer == nil {
+		return
+	}
+
+	dot11, _ := dot11Layer.(*layers.Dot11)
+	if dot11.Type != layers.Dot11TypeMgmtProbeReq {
+		return
+	}
+
+	radiotap, _ := radiotapLayer.(*layers.RadioTap)
+	channel := network.Dot11Freq2Chan(int(radiotap.ChannelFrequency))
+
+	station := w.wifi.AddClientIfNew(dot11.Address2.String(), channel)
+	station.UpdateRSSI(int8(radiotap.DBMAntennaSignal))
+
+	if dot11infoLayer := packet.Layer(layers.LayerTypeDot11InformationElement); dot11infoLayer != nil {
+		dot11info, _ := dot11infoLayer.(*layers.Dot11InformationElement)
+		if dot11info.ID == layers.Dot11InformationElementIDSSID {
+			// an empty SSID info element is a wildcard probe, not a
+			// preference for a specific network
+			station.AddProbedSSID(string(dot11info.Info))
 		}
 	}
 }
@@ -374,6 +774,9 @@ func (w *WiFiRecon) updateStats(packet gopacket.Packet) {
 	w.stats.Collect(dot11.Address2, bytes)
 	w.stats.Collect(dot11.Address3, bytes)
 	w.stats.Collect(dot11.Address4, bytes)
+
+	radiotap, _ := radiotapLayer.(*layers.RadioTap)
+	w.stats.CollectChannelFrame(network.Dot11Freq2Chan(int(radiotap.ChannelFrequency)))
 }
 
 func (w *WiFiRecon) Start() error {
@@ -383,19 +786,28 @@ func (w *WiFiRecon) Start() error {
 		return err
 	}
 
+	w.startHopping()
+
 	w.SetRunning(true, func() {
 		defer w.handle.Close()
+		defer w.stopHopping()
+
 		src := gopacket.NewPacketSource(w.handle, w.handle.LinkType())
 		for packet := range src.Packets() {
 			if w.Running() == false {
 				break
 			}
 
+			w.writePcapngFrame(packet)
+
+			w.handshakes.Process(w.Session, packet)
+
 			w.updateStats(packet)
 
 			if len(w.accessPoint) == 0 {
 				// no access point bssid selected, keep scanning for other aps
 				w.discoverAccessPoints(packet)
+				w.discoverProbeRequests(packet)
 			} else {
 				// discover stations connected to the selected access point bssid
 				w.discoverClients(w.accessPoint, packet)
@@ -407,5 +819,7 @@ func (w *WiFiRecon) Start() error {
 }
 
 func (w *WiFiRecon) Stop() error {
-	return w.SetRunning(false, nil)
+	return w.SetRunning(false, func() {
+		w.closePcapngWriter()
+	})
 }
\ No newline at end of file
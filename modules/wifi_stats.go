@@ -0,0 +1,89 @@
+package modules
+
+import (
+	"net"
+	"sort"
+	"sync"
+)
+
+// WiFiStats tracks per-station traffic counters and, since the channel
+// hopper was introduced, per-channel dwell time and frame counts so users
+// can validate that every configured channel is actually being visited.
+type WiFiStats struct {
+	sync.Mutex
+
+	bytes        map[string]uint64
+	channelDwell map[int]uint64
+	channelSeen  map[int]uint64
+}
+
+func NewWiFiStats() *WiFiStats {
+	return &WiFiStats{
+		bytes:        make(map[string]uint64),
+		channelDwell: make(map[int]uint64),
+		channelSeen:  make(map[int]uint64),
+	}
+}
+
+func (s *WiFiStats) Collect(hw net.HardwareAddr, size uint64) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.bytes[hw.String()] += size
+}
+
+func (s *WiFiStats) For(hw net.HardwareAddr) uint64 {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.bytes[hw.String()]
+}
+
+// CollectChannelDwell accumulates the milliseconds spent parked on channel.
+func (s *WiFiStats) CollectChannelDwell(channel int, ms uint64) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.channelDwell[channel] += ms
+}
+
+// CollectChannelFrame counts one more observed frame on channel.
+func (s *WiFiStats) CollectChannelFrame(channel int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.channelSeen[channel]++
+}
+
+// ChannelStats returns the accumulated dwell time in milliseconds and the
+// observed frame count for channel.
+func (s *WiFiStats) ChannelStats(channel int) (dwellMs uint64, frames uint64) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.channelDwell[channel], s.channelSeen[channel]
+}
+
+// Channels returns every channel we have dwell or frame stats for, sorted
+// in ascending order, so callers can render full coverage without knowing
+// the configured channel list up front.
+func (s *WiFiStats) Channels() []int {
+	s.Lock()
+	defer s.Unlock()
+
+	seen := make(map[int]struct{})
+	for ch := range s.channelDwell {
+		seen[ch] = struct{}{}
+	}
+	for ch := range s.channelSeen {
+		seen[ch] = struct{}{}
+	}
+
+	channels := make([]int, 0, len(seen))
+	for ch := range seen {
+		channels = append(channels, ch)
+	}
+	sort.Ints(channels)
+
+	return channels
+}
@@ -0,0 +1,26 @@
+package network
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// Default2Ghz is the set of 2.4 GHz channels scanned by the wifi hopper
+// when the user did not override wifi.recon.channels.
+var Default2Ghz = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}
+
+// Default5Ghz is the set of commonly used 5 GHz channels scanned by the
+// wifi hopper when the user did not override wifi.recon.channels.
+var Default5Ghz = []int{36, 40, 44, 48, 149, 153, 157, 161}
+
+// SetInterfaceChannel tunes iface to the given 802.11 channel by shelling
+// out to `iw`, which is already a hard runtime dependency of monitor mode
+// setups and avoids pulling in a netlink client just for this.
+func SetInterfaceChannel(iface string, channel int) error {
+	out, err := exec.Command("iw", "dev", iface, "set", "channel", strconv.Itoa(channel)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error while setting %s to channel %d: %s", iface, channel, string(out))
+	}
+	return nil
+}
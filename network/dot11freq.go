@@ -0,0 +1,40 @@
+package network
+
+// Dot11Freq2Chan converts an 802.11 channel center frequency (in MHz) to
+// its channel number, covering both the 2.4 GHz and 5 GHz bands. It
+// returns 0 if freq does not fall within any known band.
+func Dot11Freq2Chan(freq int) int {
+	switch {
+	case freq >= 2412 && freq <= 2472:
+		return ((freq - 2412) / 5) + 1
+	case freq == 2484:
+		return 14
+	case freq >= 5035 && freq <= 5865:
+		return ((freq - 5035) / 5) + 7
+	case freq >= 5875 && freq <= 5895:
+		return 177
+	}
+	return 0
+}
+
+// Dot11Chan2Freq is the inverse of Dot11Freq2Chan, converting an 802.11
+// channel number back to its center frequency in MHz. The channel hopper
+// doesn't need it since `iw set channel` takes a channel number directly;
+// it's provided so other callers translating in that direction don't have
+// to duplicate the band tables. Channels 7 to 14 exist in both bands and
+// are always mapped to their 2.4 GHz frequency, so for 5035 to 5070 MHz
+// the two functions do not round-trip. It returns 0 if channel is not part
+// of any known band.
+func Dot11Chan2Freq(channel int) int {
+	switch {
+	case channel >= 1 && channel <= 13:
+		return 2412 + (channel-1)*5
+	case channel == 14:
+		return 2484
+	case channel >= 7 && channel <= 173:
+		return 5035 + (channel-7)*5
+	case channel == 177:
+		return 5895
+	}
+	return 0
+}
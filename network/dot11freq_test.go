@@ -0,0 +1,47 @@
+package network
+
+import "testing"
+
+func TestDot11Freq2Chan(t *testing.T) {
+	cases := []struct {
+		freq int
+		chan_ int
+	}{
+		{2412, 1},
+		{2472, 13},
+		{2484, 14},
+		{5035, 7},
+		{5865, 173},
+		{5875, 177},
+		{5895, 177},
+		{0, 0},
+		{3000, 0},
+	}
+
+	for _, c := range cases {
+		if got := Dot11Freq2Chan(c.freq); got != c.chan_ {
+			t.Fatalf("Dot11Freq2Chan(%d): expected '%d', got '%d'", c.freq, c.chan_, got)
+		}
+	}
+}
+
+func TestDot11Chan2Freq(t *testing.T) {
+	cases := []struct {
+		channel int
+		freq    int
+	}{
+		{1, 2412},
+		{13, 2472},
+		{14, 2484},
+		{36, 5180},
+		{161, 5805},
+		{177, 5895},
+		{0, 0},
+	}
+
+	for _, c := range cases {
+		if got := Dot11Chan2Freq(c.channel); got != c.freq {
+			t.Fatalf("Dot11Chan2Freq(%d): expected '%d', got '%d'", c.channel, c.freq, got)
+		}
+	}
+}